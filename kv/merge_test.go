@@ -0,0 +1,135 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package kv
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+	"github.com/cockroachdb/cockroach/util/log"
+)
+
+// TestRangeMergesWithConcurrentTxns does 5 consecutive splits followed
+// by 5 consecutive merges undoing them, all while 10 concurrent
+// goroutines run successive transactions composed of a random mix of
+// puts. As with TestRangeSplitsWithConcurrentTxns, merges (like
+// splits) don't conflict with concurrent writers, so no transaction
+// should ever be forced to retry.
+func TestRangeMergesWithConcurrentTxns(t *testing.T) {
+	db, _, _ := createTestDB(t)
+	defer db.Close()
+
+	done := make(chan struct{})
+
+	const splits = 5
+	splitKeys := []engine.Key(nil)
+	for i := 0; i < splits; i++ {
+		splitKeys = append(splitKeys, engine.Key(fmt.Sprintf("%02d", i)))
+	}
+
+	const concurrency = 10
+	var retries int32
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go startTestWriter(db, int64(i), 1*time.Millisecond, &wg, &retries, done, t, nil)
+	}
+
+	for _, splitKey := range splitKeys {
+		time.Sleep(5 * time.Millisecond) // allow some time for transactions to make progress
+		log.Infof("starting split at key %q..", splitKey)
+		splitR := <-db.AdminSplit(&proto.AdminSplitRequest{RequestHeader: proto.RequestHeader{Key: splitKey}, SplitKey: splitKey})
+		if splitR.GoError() != nil {
+			t.Fatal(splitR.GoError())
+		}
+		log.Infof("split at key %q complete", splitKey)
+	}
+
+	// Merge the splits back in. AdminMerge resolves its Key argument to
+	// the range containing it and merges that range with its right-hand
+	// neighbor, so engine.KeyMin -- always the start of the leftmost
+	// range, however many merges have already happened -- consumes one
+	// split boundary per call.
+	for i := 0; i < splits; i++ {
+		time.Sleep(5 * time.Millisecond) // allow some time for transactions to make progress
+		log.Infof("starting merge %d..", i)
+		mergeR := <-db.AdminMerge(&proto.AdminMergeRequest{RequestHeader: proto.RequestHeader{Key: engine.KeyMin}})
+		if mergeR.GoError() != nil {
+			t.Fatal(mergeR.GoError())
+		}
+		log.Infof("merge %d complete", i)
+	}
+
+	close(done)
+	wg.Wait()
+
+	if retries != 0 {
+		t.Errorf("expected no retries merging a range with concurrent writes, "+
+			"as range merges do not cause conflicts; got %d", retries)
+	}
+
+	// The 5 merges above should have undone the 5 splits exactly,
+	// leaving a single range spanning the whole keyspace again. Check
+	// that directly against meta2 rather than just trusting that
+	// AdminMerge reported success: a merge whose range descriptor
+	// never actually widened (e.g. because applyMerge never ran) would
+	// still leave the old split boundaries behind.
+	var kvs []proto.KeyValue
+	txnOpts := &storage.TransactionOptions{
+		Name: "scan meta2 records",
+		Retry: &util.RetryOptions{
+			Backoff:    1 * time.Millisecond,
+			MaxBackoff: 10 * time.Millisecond,
+			Constant:   2,
+		},
+	}
+	if err := db.RunTransaction(txnOpts, func(txn storage.DB) error {
+		scanR := <-txn.Scan(&proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    engine.KeyMeta2Prefix,
+				EndKey: engine.KeyMetaMax,
+			},
+		})
+		if scanR.GoError() != nil {
+			return scanR.GoError()
+		}
+		kvs = scanR.Rows
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to scan meta2 keys: %s", err)
+	}
+	if len(kvs) != 1 {
+		t.Errorf("expected the 5 splits to have been fully merged back into a single range, got %d ranges", len(kvs))
+	}
+
+	var desc proto.RangeDescriptor
+	if err := storage.GetProto(db, kvs[0].Key, &desc); err != nil {
+		t.Fatalf("failed to fetch merged range descriptor: %s", err)
+	}
+	if !bytes.Equal(desc.StartKey, engine.KeyMin) {
+		t.Errorf("expected the merged range to start at %q, got %q", engine.KeyMin, desc.StartKey)
+	}
+	if bytes.Compare(desc.EndKey, splitKeys[len(splitKeys)-1]) <= 0 {
+		t.Errorf("expected the merged range to have absorbed all split boundaries, got EndKey %q", desc.EndKey)
+	}
+}
@@ -30,13 +30,17 @@ import (
 	"github.com/cockroachdb/cockroach/storage/engine"
 	"github.com/cockroachdb/cockroach/util"
 	"github.com/cockroachdb/cockroach/util/log"
+	"github.com/cockroachdb/cockroach/util/ratelimit"
 )
 
 // startTestWriter creates a writer which intiates a sequence of
 // transactions, each which writes up to 10 times to random keys
-// with random values.
+// with random values. If limiter is non-nil, the byte rate of the
+// Put traffic issued by this goroutine is capped at its configured
+// rate; passing the same *ratelimit.Monitor to multiple goroutines
+// caps their aggregate rate instead of each individually.
 func startTestWriter(db storage.DB, i int64, pause time.Duration, wg *sync.WaitGroup,
-	retries *int32, done <-chan struct{}, t *testing.T) {
+	retries *int32, done <-chan struct{}, t *testing.T, limiter *ratelimit.Monitor) {
 	src := rand.New(rand.NewSource(i))
 	for {
 		select {
@@ -63,6 +67,11 @@ func startTestWriter(db storage.DB, i int64, pause time.Duration, wg *sync.WaitG
 				for j := 0; j <= int(src.Int31n(10)); j++ {
 					key := []byte(util.RandString(src, 10))
 					val := []byte(util.RandString(src, int(src.Int31n(1<<8))))
+					if limiter != nil {
+						if err := limiter.Limit(int64(len(key)+len(val)), time.Time{}, done); err != nil {
+							return err
+						}
+					}
 					putR := <-txn.Put(&proto.PutRequest{RequestHeader: proto.RequestHeader{Key: key}, Value: proto.Value{Bytes: val}})
 					if putR.GoError() != nil {
 						log.Infof("experienced an error in routine %d: %s", i, putR.GoError())
@@ -72,7 +81,13 @@ func startTestWriter(db storage.DB, i int64, pause time.Duration, wg *sync.WaitG
 				return nil
 			})
 			if err != nil {
-				t.Error(err)
+				select {
+				case <-done:
+					// The test tore down and cancelled us while we
+					// were parked in limiter.Limit; not a real failure.
+				default:
+					t.Error(err)
+				}
 			} else if pause != 0 {
 				time.Sleep(pause)
 			}
@@ -103,7 +118,7 @@ func TestRangeSplitsWithConcurrentTxns(t *testing.T) {
 	var wg sync.WaitGroup
 	wg.Add(concurrency)
 	for i := 0; i < concurrency; i++ {
-		go startTestWriter(db, int64(i), 1*time.Millisecond, &wg, &retries, done, t)
+		go startTestWriter(db, int64(i), 1*time.Millisecond, &wg, &retries, done, t, nil)
 	}
 
 	// Execute the consecutive splits.
@@ -127,8 +142,13 @@ func TestRangeSplitsWithConcurrentTxns(t *testing.T) {
 }
 
 // TestRangeSplitsWithWritePressure sets the zone config max bytes for
-// a range to 1K and writes data until there are five ranges.
+// a range to 1K and writes data until there are five ranges. The
+// writer is capped at writePressureRate bytes/sec so that the 500ms
+// timing assertion below reflects a bounded, reproducible ingest rate
+// rather than "as fast as this machine can go," which made the
+// assertion flaky on slow CI machines.
 func TestRangeSplitsWithWritePressure(t *testing.T) {
+	const writePressureRate = 256 << 10 // 256KB/sec
 	db, _, _ := createTestDB(t)
 	defer db.Close()
 	txnOpts := &storage.TransactionOptions{
@@ -154,9 +174,12 @@ func TestRangeSplitsWithWritePressure(t *testing.T) {
 		t.Fatal(err)
 	}
 
-	// Start test writer.
+	// Start test writer, capped at writePressureRate bytes/sec.
 	done := make(chan struct{})
-	go startTestWriter(db, int64(0), 500*time.Microsecond, nil, nil, done, t)
+	limiter := ratelimit.NewMonitor(writePressureRate)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go startTestWriter(db, int64(0), 500*time.Microsecond, &wg, nil, done, t, limiter)
 
 	// Check that we split 5 times with (a very generous for slow test machines) 500ms.
 	if err := util.IsTrueWithin(func() bool {
@@ -182,4 +205,102 @@ func TestRangeSplitsWithWritePressure(t *testing.T) {
 		t.Errorf("failed to split 5 times: %s", err)
 	}
 	close(done)
+	wg.Wait()
+
+	if bytes, samples, _, rEMA := limiter.Stats(); samples == 0 || bytes == 0 {
+		t.Errorf("expected the write pressure limiter to record traffic, got bytes=%d samples=%d rEMA=%f",
+			bytes, samples, rEMA)
+	}
+}
+
+// TestRangeSplitQueueBackpressure verifies that a range accumulates
+// splits at roughly the ingest rate it is fed, by driving a fixed
+// aggregate write rate across several concurrent writers sharing a
+// single rate limiter and checking that the number of splits
+// observed after a fixed interval is bounded above and below.
+func TestRangeSplitQueueBackpressure(t *testing.T) {
+	const ingestRate = 128 << 10 // 128KB/sec aggregate across all writers
+	const concurrency = 4
+	db, _, _ := createTestDB(t)
+	defer db.Close()
+
+	const rangeMaxBytes = 1 << 10
+	zoneConfig := &proto.ZoneConfig{
+		ReplicaAttrs: []proto.Attributes{
+			proto.Attributes{},
+			proto.Attributes{},
+			proto.Attributes{},
+		},
+		RangeMinBytes: 1 << 8,
+		RangeMaxBytes: rangeMaxBytes,
+	}
+	if err := storage.PutProto(db, engine.MakeKey(engine.KeyConfigZonePrefix, engine.KeyMin), zoneConfig); err != nil {
+		t.Fatal(err)
+	}
+	txnOpts := &storage.TransactionOptions{
+		Name: "scan meta2 records",
+		Retry: &util.RetryOptions{
+			Backoff:    1 * time.Millisecond,
+			MaxBackoff: 10 * time.Millisecond,
+			Constant:   2,
+		},
+	}
+
+	done := make(chan struct{})
+	limiter := ratelimit.NewMonitor(ingestRate)
+	// A new Monitor starts with one second's worth of tokens. Drain them
+	// so the writers are held to ingestRate from the start, and leave
+	// the drained bytes out of the count below.
+	if err := limiter.Limit(ingestRate, time.Time{}, nil); err != nil {
+		t.Fatal(err)
+	}
+	drained, _, _, _ := limiter.Stats()
+	var wg sync.WaitGroup
+	wg.Add(concurrency)
+	for i := 0; i < concurrency; i++ {
+		go startTestWriter(db, int64(i), 0, &wg, nil, done, t, limiter)
+	}
+
+	const runFor = 250 * time.Millisecond
+	time.Sleep(runFor)
+	close(done)
+	wg.Wait()
+
+	bytes, _, _, rEMA := limiter.Stats()
+	bytes -= drained
+	maxExpected := int64(ingestRate*runFor.Seconds()) * 2 // generous slack for scheduling jitter
+	if bytes > maxExpected {
+		t.Errorf("expected aggregate writes to stay near %d bytes/sec, wrote %d bytes in %s (rEMA=%f)",
+			ingestRate, bytes, runFor, rEMA)
+	}
+
+	// The limiter caps aggregate ingest at ingestRate bytes/sec, so the
+	// split queue should have accumulated on the order of
+	// (ingestRate*runFor)/rangeMaxBytes splits: enough to keep up with
+	// backpressure, not so many that the queue is racing ahead of the
+	// ingest it's supposed to be bounded by.
+	var kvs []proto.KeyValue
+	if err := db.RunTransaction(txnOpts, func(txn storage.DB) error {
+		scanR := <-txn.Scan(&proto.ScanRequest{
+			RequestHeader: proto.RequestHeader{
+				Key:    engine.KeyMeta2Prefix,
+				EndKey: engine.KeyMetaMax,
+			},
+		})
+		if scanR.GoError() != nil {
+			return scanR.GoError()
+		}
+		kvs = scanR.Rows
+		return nil
+	}); err != nil {
+		t.Fatalf("failed to scan meta2 keys: %s", err)
+	}
+	numRanges := int64(len(kvs))
+
+	expectedSplits := int64(ingestRate*runFor.Seconds()) / rangeMaxBytes
+	minRanges, maxRanges := 1+expectedSplits/4, 1+expectedSplits*4 // generous slack for scheduling jitter
+	if numRanges < minRanges || numRanges > maxRanges {
+		t.Errorf("expected between %d and %d ranges after %s at %d bytes/sec with %d-byte ranges, got %d",
+			minRanges, maxRanges, runFor, ingestRate, rangeMaxBytes, numRanges)
+	}
 }
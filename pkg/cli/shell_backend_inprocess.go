@@ -0,0 +1,80 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cli
+
+import (
+	"fmt"
+
+	"github.com/cockroachdb/cockroach/pkg/server"
+)
+
+// inProcessConn is a ShellBackend that executes statements directly
+// against a *server.TestServer's SQL executor, without going through
+// pgwire or opening a network connection. It exists so tests of the
+// shell itself (statement splitting, table rendering, ...) don't need
+// to also stand up TLS and a listening socket just to get a backend
+// to talk to.
+type inProcessConn struct {
+	ts *server.TestServer
+}
+
+// makeInProcessSQLConn creates a ShellBackend that executes
+// statements directly against ts, in-process.
+func makeInProcessSQLConn(ts *server.TestServer) ShellBackend {
+	return &inProcessConn{ts: ts}
+}
+
+// Exec implements the ShellBackend interface.
+func (c *inProcessConn) Exec(stmt string) error {
+	_, err := c.ts.Executor().Exec(stmt)
+	return err
+}
+
+// Query implements the ShellBackend interface.
+func (c *inProcessConn) Query(stmt string) (*sqlRows, error) {
+	res, err := c.ts.Executor().Exec(stmt)
+	if err != nil {
+		return nil, err
+	}
+	cols, vals := res.Columns(), res.Rows()
+	return &sqlRows{cols: cols, vals: vals}, nil
+}
+
+// Close implements the ShellBackend interface. The underlying test
+// server outlives the shell, so there is nothing to release here.
+func (c *inProcessConn) Close() {}
+
+// ServerVersion implements the ShellBackend interface.
+func (c *inProcessConn) ServerVersion() (string, error) {
+	rows, err := c.Query("SELECT version()")
+	if err != nil {
+		return "", err
+	}
+	if len(rows.vals) == 0 || len(rows.vals[0]) == 0 {
+		return "", fmt.Errorf("sql: server did not report a version")
+	}
+	return rows.vals[0][0], nil
+}
+
+// Lex implements the ShellBackend interface by handing sql to the
+// same parser the in-process executor uses.
+func (c *inProcessConn) Lex(sql string) (bool, error) {
+	if _, err := c.ts.Executor().Parse(sql); err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+var _ ShellBackend = (*inProcessConn)(nil)
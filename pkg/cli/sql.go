@@ -0,0 +1,362 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cli
+
+import (
+	"database/sql"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+
+	"github.com/chzyer/readline"
+	_ "github.com/lib/pq"
+)
+
+// tableDisplayFormat selects how query results are rendered by the
+// interactive shell.
+type tableDisplayFormat int
+
+const (
+	tableDisplayPretty tableDisplayFormat = iota
+	tableDisplayCSV
+	tableDisplayTSV
+)
+
+// cliCtx holds settings shared across the various cli subcommands.
+var cliCtx = struct {
+	tableDisplayFormat tableDisplayFormat
+}{
+	tableDisplayFormat: tableDisplayPretty,
+}
+
+// sqlRows is the minimal result-set interface the interactive shell
+// needs from a ShellBackend in order to render output: column names
+// and the rows themselves, both already converted to display strings.
+type sqlRows struct {
+	cols []string
+	vals [][]string
+}
+
+// ShellBackend abstracts the SQL engine that the interactive shell
+// talks to. The shell itself only ever calls through this interface,
+// which keeps it independent from any one transport: today that's a
+// pgwire connection (sqlConn, below) or an in-process backend wired
+// directly to a test server (see shell_backend_inprocess.go), and
+// tomorrow it could be a backend that replays statements from a file
+// for regression testing.
+type ShellBackend interface {
+	// Exec runs stmt and discards any result rows.
+	Exec(stmt string) error
+	// Query runs stmt and returns its result rows.
+	Query(stmt string) (*sqlRows, error)
+	// Close releases any resources held by the backend.
+	Close()
+	// ServerVersion reports the version string reported by the
+	// backend's SQL engine.
+	ServerVersion() (string, error)
+	// Lex reports whether sql is recognized by the backend's own SQL
+	// parser as a sequence of one or more complete statements. It lets
+	// callers validate statement boundaries against a real parser
+	// instead of the shell's own heuristics.
+	Lex(sql string) (bool, error)
+}
+
+// sqlConn is the pgwire-backed ShellBackend used when the shell talks
+// to a server over the network. The connection is dialed lazily, on
+// the first call that needs it, via ensureConn.
+type sqlConn struct {
+	url  string
+	conn *sql.DB
+}
+
+// makeSQLConn creates a ShellBackend that talks to the server at url
+// over pgwire. Dialing is deferred to the first call that actually
+// needs the connection.
+func makeSQLConn(url string) *sqlConn {
+	return &sqlConn{url: url}
+}
+
+// ensureConn dials the server if this is the first call to need a
+// live connection.
+func (c *sqlConn) ensureConn() (*sql.DB, error) {
+	if c.conn != nil {
+		return c.conn, nil
+	}
+	db, err := sql.Open("postgres", c.url)
+	if err != nil {
+		return nil, fmt.Errorf("sql: opening connection: %s", err)
+	}
+	if err := db.Ping(); err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("sql: connecting to %s: %s", c.url, err)
+	}
+	c.conn = db
+	return db, nil
+}
+
+// Exec implements the ShellBackend interface.
+func (c *sqlConn) Exec(stmt string) error {
+	db, err := c.ensureConn()
+	if err != nil {
+		return err
+	}
+	_, err = db.Exec(stmt)
+	return err
+}
+
+// Query implements the ShellBackend interface.
+func (c *sqlConn) Query(stmt string) (*sqlRows, error) {
+	db, err := c.ensureConn()
+	if err != nil {
+		return nil, err
+	}
+	rows, err := db.Query(stmt)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	return scanRows(rows)
+}
+
+// scanRows drains rows into a *sqlRows, converting every column value
+// to its display string.
+func scanRows(rows *sql.Rows) (*sqlRows, error) {
+	cols, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &sqlRows{cols: cols}
+	dest := make([]interface{}, len(cols))
+	rawVals := make([]interface{}, len(cols))
+	for i := range dest {
+		dest[i] = &rawVals[i]
+	}
+
+	for rows.Next() {
+		if err := rows.Scan(dest...); err != nil {
+			return nil, err
+		}
+		vals := make([]string, len(cols))
+		for i, v := range rawVals {
+			if b, ok := v.([]byte); ok {
+				vals[i] = string(b)
+			} else {
+				vals[i] = fmt.Sprint(v)
+			}
+		}
+		res.vals = append(res.vals, vals)
+	}
+	return res, rows.Err()
+}
+
+// Close implements the ShellBackend interface.
+func (c *sqlConn) Close() {
+	if c.conn != nil {
+		_ = c.conn.Close()
+		c.conn = nil
+	}
+}
+
+// ServerVersion implements the ShellBackend interface.
+func (c *sqlConn) ServerVersion() (string, error) {
+	rows, err := c.Query("SELECT version()")
+	if err != nil {
+		return "", err
+	}
+	if len(rows.vals) == 0 || len(rows.vals[0]) == 0 {
+		return "", fmt.Errorf("sql: server did not report a version")
+	}
+	return rows.vals[0][0], nil
+}
+
+// Lex implements the ShellBackend interface by running sql inside a
+// transaction that is always rolled back, relying on the server's
+// parser and executor to validate it. A transaction -- rather than
+// PREPARE, which only accepts SELECT/INSERT/UPDATE/DELETE/VALUES --
+// accepts any statement the server does, including DDL, and leaves no
+// server-side state behind for the next call to collide with.
+func (c *sqlConn) Lex(sql string) (bool, error) {
+	db, err := c.ensureConn()
+	if err != nil {
+		return false, err
+	}
+	tx, err := db.Begin()
+	if err != nil {
+		return false, err
+	}
+	_, err = tx.Exec(sql)
+	_ = tx.Rollback()
+	if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+var _ ShellBackend = (*sqlConn)(nil)
+
+// printPrettyTable renders rows as an ASCII table, the shell's
+// default display format.
+func printPrettyTable(rows *sqlRows) {
+	widths := make([]int, len(rows.cols))
+	for i, col := range rows.cols {
+		widths[i] = len(col)
+	}
+	for _, row := range rows.vals {
+		for i, val := range row {
+			if len(val) > widths[i] {
+				widths[i] = len(val)
+			}
+		}
+	}
+
+	printSeparator := func() {
+		for _, w := range widths {
+			fmt.Print("+", strings.Repeat("-", w+2))
+		}
+		fmt.Println("+")
+	}
+	printRow := func(vals []string) {
+		for i, v := range vals {
+			fmt.Printf("| %-*s ", widths[i], v)
+		}
+		fmt.Println("|")
+	}
+
+	printSeparator()
+	printRow(rows.cols)
+	printSeparator()
+	for _, row := range rows.vals {
+		printRow(row)
+	}
+	printSeparator()
+	fmt.Printf("(%d row", len(rows.vals))
+	if len(rows.vals) != 1 {
+		fmt.Print("s")
+	}
+	fmt.Println(")")
+}
+
+// runInteractive runs the interactive SQL shell against backend,
+// reading statements from conf.Stdin (or the terminal, if conf.Stdin
+// is nil) until EOF. Statement boundaries are tracked incrementally
+// by a StatementSplitter, so a pasted multi-statement blob is
+// recognized a line at a time rather than by re-lexing everything
+// typed so far on every keystroke; when a single line yields more
+// than one complete statement (a pasted blob, or a \i'd script) and
+// none of them can produce result rows, they are sent to backend as a
+// single Exec batch instead of one round-trip each. A batch
+// containing a row-producing statement (SELECT, SHOW, ...) is run one
+// statement at a time instead, since ShellBackend has no way to
+// return more than one statement's rows from a single call.
+func runInteractive(backend ShellBackend, conf *readline.Config) error {
+	rl, err := readline.NewEx(conf)
+	if err != nil {
+		return err
+	}
+	defer func() { _ = rl.Close() }()
+
+	sp := NewStatementSplitter()
+	for {
+		line, err := rl.Readline()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+
+		_, _ = sp.Write([]byte(line + "\n"))
+		stmts := sp.Statements()
+		if len(stmts) == 0 {
+			continue
+		}
+		if len(stmts) > 1 && !anyMayProduceRows(stmts) {
+			batch := make([]string, len(stmts))
+			for i, stmt := range stmts {
+				batch[i] = stmt.SQL
+			}
+			if err := backend.Exec(strings.Join(batch, "")); err != nil {
+				fmt.Fprintf(rl.Stderr(), "%s\n", err)
+			}
+			continue
+		}
+
+		for _, stmt := range stmts {
+			if err := runShellStatement(backend, stmt.SQL); err != nil {
+				fmt.Fprintf(rl.Stderr(), "%s\n", err)
+			}
+		}
+	}
+	return nil
+}
+
+// rowProducingPrefixes are the leading keywords of statements that
+// can return result rows. anyMayProduceRows uses this list to decide
+// whether a batch of statements is safe to send through Exec, which
+// discards rows, or must instead be run one at a time through Query.
+var rowProducingPrefixes = []string{"SELECT", "SHOW", "EXPLAIN", "VALUES"}
+
+// returningClauseRe matches a RETURNING clause: INSERT, UPSERT,
+// UPDATE and DELETE don't lead with a row-producing keyword, but can
+// still return rows via RETURNING.
+var returningClauseRe = regexp.MustCompile(`(?i)\bRETURNING\b`)
+
+// anyMayProduceRows reports whether any of stmts could return result
+// rows, based on its leading keyword or a RETURNING clause.
+func anyMayProduceRows(stmts []Statement) bool {
+	for _, stmt := range stmts {
+		word := strings.ToUpper(strings.TrimLeft(stmt.SQL, " \t\r\n("))
+		for _, prefix := range rowProducingPrefixes {
+			if strings.HasPrefix(word, prefix) {
+				return true
+			}
+		}
+		if returningClauseRe.MatchString(stmt.SQL) {
+			return true
+		}
+	}
+	return false
+}
+
+// runShellStatement executes a single, already-delimited statement
+// and prints its result according to cliCtx.tableDisplayFormat.
+func runShellStatement(backend ShellBackend, stmt string) error {
+	rows, err := backend.Query(stmt)
+	if err != nil {
+		return err
+	}
+	printQueryOutput(rows)
+	return nil
+}
+
+// printQueryOutput renders rows to stdout using the format selected
+// by cliCtx.tableDisplayFormat.
+func printQueryOutput(rows *sqlRows) {
+	switch cliCtx.tableDisplayFormat {
+	case tableDisplayCSV, tableDisplayTSV:
+		sep := ","
+		if cliCtx.tableDisplayFormat == tableDisplayTSV {
+			sep = "\t"
+		}
+		fmt.Println(strings.Join(rows.cols, sep))
+		for _, row := range rows.vals {
+			fmt.Println(strings.Join(row, sep))
+		}
+	default:
+		printPrettyTable(rows)
+	}
+}
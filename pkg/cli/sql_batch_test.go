@@ -0,0 +1,56 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+)
+
+// TestAnyMayProduceRows verifies that a batch containing any
+// row-producing statement is flagged as such, so runInteractive
+// doesn't send it through the rows-discarding Exec batch path.
+func TestAnyMayProduceRows(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tests := []struct {
+		stmts []string
+		want  bool
+	}{
+		{stmts: []string{"INSERT INTO t VALUES (1);"}, want: false},
+		{stmts: []string{"UPDATE t SET a = 1;", "DELETE FROM t;"}, want: false},
+		{stmts: []string{"INSERT INTO t VALUES (1);", "SELECT 1;"}, want: true},
+		{stmts: []string{"  select 1;"}, want: true},
+		{stmts: []string{"SHOW TABLES;"}, want: true},
+		{stmts: []string{"EXPLAIN SELECT 1;"}, want: true},
+		{stmts: []string{"VALUES (1);"}, want: true},
+		{stmts: []string{"INSERT INTO t VALUES (1) RETURNING a;"}, want: true},
+		{stmts: []string{"UPDATE t SET a = 1 RETURNING a;"}, want: true},
+		{stmts: []string{"DELETE FROM t RETURNING a;"}, want: true},
+		{stmts: []string{"UPSERT INTO t VALUES (1) RETURNING a;"}, want: true},
+		{stmts: []string{"insert into t values (1) returning a;"}, want: true},
+	}
+
+	for _, test := range tests {
+		stmts := make([]Statement, len(test.stmts))
+		for i, sql := range test.stmts {
+			stmts[i] = Statement{SQL: sql}
+		}
+		if got := anyMayProduceRows(stmts); got != test.want {
+			t.Errorf("%v: expected %v, got %v", test.stmts, test.want, got)
+		}
+	}
+}
@@ -0,0 +1,77 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cli
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/security"
+	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+)
+
+// TestSQLConnPgwire drives the pgwire-backed ShellBackend end to end
+// against a real server, so a regression that leaves sqlConn
+// unconnected (as opposed to the in-process backend used by
+// TestSQLLex) doesn't go unnoticed.
+func TestSQLConnPgwire(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	c := newCLITest(cliTestParams{t: t})
+	defer c.cleanup()
+
+	pgurl, cleanup := sqlutils.PGUrl(t, c.ServingAddr(), t.Name(), url.User(security.RootUser))
+	defer cleanup()
+
+	conn := makeSQLConn(pgurl.String())
+	defer conn.Close()
+
+	if err := conn.Exec("SELECT 1"); err != nil {
+		t.Fatalf("unexpected error execing over pgwire: %s", err)
+	}
+
+	rows, err := conn.Query("SELECT 1 AS a, 2 AS b")
+	if err != nil {
+		t.Fatalf("unexpected error querying over pgwire: %s", err)
+	}
+	if len(rows.vals) != 1 || len(rows.vals[0]) != 2 {
+		t.Fatalf("expected one row of two columns, got %#v", rows.vals)
+	}
+	if rows.vals[0][0] != "1" || rows.vals[0][1] != "2" {
+		t.Errorf("expected [\"1\" \"2\"], got %#v", rows.vals[0])
+	}
+
+	if _, err := conn.ServerVersion(); err != nil {
+		t.Errorf("unexpected error fetching server version: %s", err)
+	}
+
+	// DDL isn't valid under PREPARE, so this also guards against a
+	// naive Lex implementation built on top of it.
+	if ok, err := conn.Lex("CREATE TABLE lex_test (a INT)"); err != nil || !ok {
+		t.Errorf("expected CREATE TABLE to lex as valid, got ok=%v err=%s", ok, err)
+	}
+
+	// A second call must not fail just because the first one happened:
+	// Lex must not leave any server-side state (e.g. a named prepared
+	// statement) behind for the next call to collide with.
+	if ok, err := conn.Lex("SELECT 1"); err != nil || !ok {
+		t.Errorf("expected second Lex call to succeed, got ok=%v err=%s", ok, err)
+	}
+
+	if ok, err := conn.Lex("SELECT 1 FROM"); err == nil || ok {
+		t.Errorf("expected invalid SQL to fail to lex, got ok=%v err=%s", ok, err)
+	}
+}
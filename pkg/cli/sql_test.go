@@ -15,27 +15,24 @@
 package cli
 
 import (
-	"net/url"
 	"strings"
 	"testing"
 
 	"github.com/chzyer/readline"
-	"github.com/cockroachdb/cockroach/pkg/security"
-	"github.com/cockroachdb/cockroach/pkg/testutils/sqlutils"
 	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
 )
 
-// TestSQLLex tests the usage of the lexer in the sql subcommand.
+// TestSQLLex tests the usage of the lexer in the sql subcommand. It
+// runs against an in-process ShellBackend rather than a real pgwire
+// connection, so it doesn't need to set up PGUrl/TLS just to exercise
+// the shell's read loop and output formatting.
 func TestSQLLex(t *testing.T) {
 	defer leaktest.AfterTest(t)()
 
 	c := newCLITest(cliTestParams{t: t})
 	defer c.cleanup()
 
-	pgurl, cleanup := sqlutils.PGUrl(t, c.ServingAddr(), t.Name(), url.User(security.RootUser))
-	defer cleanup()
-
-	conn := makeSQLConn(pgurl.String())
+	conn := makeInProcessSQLConn(c.TestServer())
 	defer conn.Close()
 
 	tests := []struct {
@@ -0,0 +1,253 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cli
+
+import (
+	"regexp"
+	"strings"
+	"unicode"
+)
+
+// dollarTagRe matches a $tag$ (or bare $$) opening delimiter that has
+// just been completed at the end of the scanned text.
+var dollarTagRe = regexp.MustCompile(`\$([A-Za-z_][A-Za-z0-9_]*)?\$$`)
+
+// escapeStringPrefixRe matches a standalone E or e immediately
+// preceding the position it's tested against -- the CockroachDB/
+// Postgres E'...' escape-string prefix -- as opposed to one that's
+// merely the tail of a longer identifier.
+var escapeStringPrefixRe = regexp.MustCompile(`(^|[^A-Za-z0-9_$])[Ee]$`)
+
+// lexState is the StatementSplitter's internal lexer state, carried
+// across Write calls so a statement spanning several reads (one per
+// line from the terminal, say) doesn't require re-scanning text
+// that's already been classified.
+type lexState int
+
+const (
+	lexNormal lexState = iota
+	lexString
+	lexDollarQuote
+	lexLineComment
+	lexBlockComment
+	lexIdentQuote
+)
+
+// Statement is a single complete SQL statement recognized by a
+// StatementSplitter, including its terminating ';'.
+type Statement struct {
+	SQL string
+	// TrailingEmpty is true if, as of the last call to Statements,
+	// only whitespace and/or comments have been seen after this
+	// statement's ';' — i.e. nothing suggests another statement is
+	// being typed right behind it.
+	TrailingEmpty bool
+}
+
+// StatementSplitter incrementally splits a stream of SQL text into
+// complete statements as they're recognized, rather than re-lexing
+// the whole accumulated buffer on every call. It tracks lexer state
+// across Write calls: whether it's inside a single-quoted string, a
+// dollar-quoted string (along with the string's tag), a double-quoted
+// identifier, a '--' line comment, or a nested /* ... */ block
+// comment.
+type StatementSplitter struct {
+	state      lexState
+	dollarTag  string
+	blockDepth int
+
+	stmt          strings.Builder
+	last          byte
+	sawText       bool
+	stringEscaped bool // current '...' string was opened with an E/e prefix
+	escapeNext    bool // next byte in the current string is backslash-escaped
+
+	// pendingOpener holds a '-' or '/' byte already written to stmt
+	// whose classification as text vs. the start of a '--' or '/*'
+	// comment can't be decided until the following byte arrives.
+	pendingOpener byte
+
+	pending    []Statement
+	trailingAt int // index into pending whose TrailingEmpty still tracks sawText, or -1
+}
+
+// NewStatementSplitter creates an empty StatementSplitter.
+func NewStatementSplitter() *StatementSplitter {
+	return &StatementSplitter{trailingAt: -1}
+}
+
+// Write feeds p into the splitter, always consuming all of it.
+// Statements completed as a result are queued; call Statements to
+// retrieve and clear them.
+func (s *StatementSplitter) Write(p []byte) (int, error) {
+	for _, c := range p {
+		s.writeByte(c)
+	}
+	return len(p), nil
+}
+
+// Statements returns and clears the statements completed since the
+// last call to Statements.
+func (s *StatementSplitter) Statements() []Statement {
+	out := s.pending
+	s.pending = nil
+	s.trailingAt = -1
+	return out
+}
+
+func (s *StatementSplitter) writeByte(c byte) {
+	prev := s.last
+	s.last = c
+	s.stmt.WriteByte(c)
+
+	switch s.state {
+	case lexLineComment:
+		if c == '\n' {
+			s.state = lexNormal
+		}
+		return
+	case lexBlockComment:
+		switch {
+		case prev == '/' && c == '*':
+			s.blockDepth++
+			// The '*' just consumed opened this nesting level; it
+			// can't also be the '*' that closes it, so it must not
+			// be seen as prev on the next byte.
+			s.last = 0
+		case prev == '*' && c == '/':
+			s.blockDepth--
+			if s.blockDepth == 0 {
+				s.state = lexNormal
+			}
+		}
+		return
+	case lexString:
+		if s.stringEscaped {
+			if s.escapeNext {
+				s.escapeNext = false
+				return
+			}
+			if c == '\\' {
+				s.escapeNext = true
+				return
+			}
+		}
+		if c == '\'' {
+			s.state = lexNormal
+		}
+		return
+	case lexIdentQuote:
+		// A doubled "" is the standard escape for a literal '"' inside
+		// a quoted identifier. It needs no extra tracking here: this
+		// close immediately followed by the reopen a few lines below
+		// (lexNormal's case c == '"') toggles right back into
+		// lexIdentQuote, so everything between the doubled quotes and
+		// the identifier's real close is still treated as inside it.
+		if c == '"' {
+			s.state = lexNormal
+		}
+		return
+	case lexDollarQuote:
+		if c == '$' && strings.HasSuffix(s.stmt.String(), "$"+s.dollarTag+"$") {
+			s.state = lexNormal
+			s.dollarTag = ""
+		}
+		return
+	}
+
+	// lexNormal. A '-' or '/' byte is held back in pendingOpener rather
+	// than classified immediately: only the following byte reveals
+	// whether it started a '--' or '/*' comment, and until that's
+	// known it must not be counted as text (see markText).
+	if s.pendingOpener != 0 {
+		opener := s.pendingOpener
+		s.pendingOpener = 0
+		switch {
+		case opener == '-' && c == '-':
+			s.state = lexLineComment
+			return
+		case opener == '/' && c == '*':
+			s.state = lexBlockComment
+			s.blockDepth = 1
+			// The '*' just consumed opened this comment; it can't
+			// also be the '*' that closes it (see the lexBlockComment
+			// case below), so it must not be seen as prev on the next
+			// byte.
+			s.last = 0
+			return
+		default:
+			// The held-back byte wasn't the start of a comment after all.
+			s.markText()
+		}
+	}
+
+	switch {
+	case c == '\'':
+		s.state = lexString
+		s.escapeNext = false
+		prefix := s.stmt.String()
+		s.stringEscaped = escapeStringPrefixRe.MatchString(prefix[:len(prefix)-1])
+	case c == '"':
+		s.state = lexIdentQuote
+	case c == '-' || c == '/':
+		s.pendingOpener = c
+	case c == '$':
+		if m := dollarTagRe.FindStringSubmatch(s.stmt.String()); m != nil {
+			s.state = lexDollarQuote
+			s.dollarTag = m[1]
+		}
+	case c == ';':
+		s.completeStatement()
+	case !unicode.IsSpace(rune(c)):
+		s.markText()
+	}
+}
+
+// markText records that non-blank, non-comment content has been seen
+// since the last completed statement, and finalizes that statement's
+// TrailingEmpty as false.
+func (s *StatementSplitter) markText() {
+	s.sawText = true
+	if s.trailingAt >= 0 {
+		s.pending[s.trailingAt].TrailingEmpty = false
+		s.trailingAt = -1
+	}
+}
+
+// completeStatement closes out the statement accumulated so far,
+// terminated by the ';' just written, and starts a new one.
+func (s *StatementSplitter) completeStatement() {
+	s.pending = append(s.pending, Statement{SQL: s.stmt.String(), TrailingEmpty: true})
+	s.trailingAt = len(s.pending) - 1
+	s.stmt.Reset()
+	s.sawText = false
+}
+
+// isEndOfStatement reports whether buf — the accumulated input typed
+// so far — is blank (isEmpty), or represents one or more complete
+// statements with nothing but whitespace and/or comments following
+// the last ';' (isEnd). It's a one-shot convenience wrapper around
+// StatementSplitter; the interactive read loop uses a
+// StatementSplitter directly so it doesn't re-lex the whole buffer on
+// every line.
+func isEndOfStatement(buf string) (isEmpty, isEnd bool) {
+	sp := NewStatementSplitter()
+	_, _ = sp.Write([]byte(buf))
+	stmts := sp.Statements()
+	if len(stmts) == 0 {
+		return !sp.sawText, false
+	}
+	return false, stmts[len(stmts)-1].TrailingEmpty
+}
@@ -0,0 +1,204 @@
+// Copyright 2016 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License.
+
+package cli
+
+import (
+	"testing"
+
+	"github.com/cockroachdb/cockroach/pkg/util/leaktest"
+)
+
+// TestStatementSplitter covers cases the pre-existing isEndOfStatement
+// tests didn't exercise: dollar-quoted strings, nested block comments,
+// and E'...' escape strings.
+func TestStatementSplitter(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tests := []struct {
+		in    string
+		stmts []string
+	}{
+		{
+			in:    "SELECT 1;",
+			stmts: []string{"SELECT 1;"},
+		},
+		{
+			in:    "SELECT 1; SELECT 2;",
+			stmts: []string{"SELECT 1;", " SELECT 2;"},
+		},
+		{
+			// A dollar-quoted string with a tag containing what would
+			// otherwise look like statement-ending punctuation.
+			in:    "CREATE FUNCTION f() RETURNS INT AS $tag$ SELECT 1; $tag$ LANGUAGE SQL;",
+			stmts: []string{"CREATE FUNCTION f() RETURNS INT AS $tag$ SELECT 1; $tag$ LANGUAGE SQL;"},
+		},
+		{
+			// Bare $$ quoting (no tag).
+			in:    "SELECT $$it's; a string$$;",
+			stmts: []string{"SELECT $$it's; a string$$;"},
+		},
+		{
+			// Nested block comments.
+			in:    "SELECT 1 /* outer /* inner; */ still commented */;",
+			stmts: []string{"SELECT 1 /* outer /* inner; */ still commented */;"},
+		},
+		{
+			// The '*' that opens a block comment must not also be
+			// read as the '*' that closes it: "/*/" opens a comment,
+			// it doesn't open-then-immediately-close one.
+			in:    "SELECT 1 /*/ still commented; */;",
+			stmts: []string{"SELECT 1 /*/ still commented; */;"},
+		},
+		{
+			// An E'...' escape string containing a semicolon and an
+			// escaped quote.
+			in:    `SELECT E'a;\'b';`,
+			stmts: []string{`SELECT E'a;\'b';`},
+		},
+		{
+			// A plain (non-E) string does not treat '\' as an escape
+			// char: the trailing backslash here does not escape the
+			// closing quote, so the string ends right after it.
+			in:    `SELECT 'trailing backslash: \';`,
+			stmts: []string{`SELECT 'trailing backslash: \';`},
+		},
+		{
+			// A double-quoted identifier.
+			in:    `SELECT 1 FROM "my table";`,
+			stmts: []string{`SELECT 1 FROM "my table";`},
+		},
+		{
+			// A double-quoted identifier containing a semicolon and a
+			// '--' that must not be read as a line comment.
+			in:    `SELECT 1 FROM "weird;--name";`,
+			stmts: []string{`SELECT 1 FROM "weird;--name";`},
+		},
+		{
+			// A doubled "" inside a quoted identifier is the standard
+			// escape for a literal '"', not the identifier's close:
+			// this identifier is ab"cd, not ab followed by cd.
+			in:    `SELECT 1 FROM "ab""cd";`,
+			stmts: []string{`SELECT 1 FROM "ab""cd";`},
+		},
+		{
+			// A line comment hides the semicolon that follows it.
+			in:    "SELECT 1 -- ends with ; not really\n;",
+			stmts: []string{"SELECT 1 -- ends with ; not really\n;"},
+		},
+	}
+
+	for _, test := range tests {
+		sp := NewStatementSplitter()
+		if _, err := sp.Write([]byte(test.in)); err != nil {
+			t.Fatalf("%q: unexpected error: %s", test.in, err)
+		}
+		stmts := sp.Statements()
+		if len(stmts) != len(test.stmts) {
+			t.Fatalf("%q: expected %d statements, got %d: %#v", test.in, len(test.stmts), len(stmts), stmts)
+		}
+		for i, want := range test.stmts {
+			if stmts[i].SQL != want {
+				t.Errorf("%q: statement %d: expected %q, got %q", test.in, i, want, stmts[i].SQL)
+			}
+		}
+	}
+}
+
+// TestStatementSplitterAcrossWrites verifies that a statement can be
+// recognized when fed to Write in several separate calls, as happens
+// when the interactive shell reads one line at a time.
+func TestStatementSplitterAcrossWrites(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	sp := NewStatementSplitter()
+	chunks := []string{"SELECT $tag$multi\n", "line$tag$", ";\n"}
+	for _, c := range chunks {
+		if _, err := sp.Write([]byte(c)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	stmts := sp.Statements()
+	if len(stmts) != 1 {
+		t.Fatalf("expected 1 statement, got %d: %#v", len(stmts), stmts)
+	}
+	const want = "SELECT $tag$multi\nline$tag$;"
+	if stmts[0].SQL != want {
+		t.Errorf("expected %q, got %q", want, stmts[0].SQL)
+	}
+}
+
+// TestStatementSplitterCommentOpenerLookahead verifies that a '-' or
+// '/' byte isn't classified as real text until the following byte
+// confirms it isn't the start of a '--' or '/*' comment: a
+// comment-only line must still count as empty, and a lone '-'/'/'
+// that turns out not to start a comment (e.g. division) must still
+// count as text.
+func TestStatementSplitterCommentOpenerLookahead(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	// A comment-only line, with nothing else, is empty: this is the
+	// pre-existing isEndOfStatement case this bug broke.
+	isEmpty, isEnd := isEndOfStatement("  -- hello")
+	if !isEmpty || isEnd {
+		t.Errorf("%q: expected isEmpty=true, isEnd=false, got isEmpty=%v, isEnd=%v", "  -- hello", isEmpty, isEnd)
+	}
+
+	isEmpty, isEnd = isEndOfStatement("  /* hello */")
+	if !isEmpty || isEnd {
+		t.Errorf("%q: expected isEmpty=true, isEnd=false, got isEmpty=%v, isEnd=%v", "  /* hello */", isEmpty, isEnd)
+	}
+
+	// A '/' that turns out to be division, not a comment, is real
+	// text and must still complete the statement.
+	sp := NewStatementSplitter()
+	const in = "SELECT 4/2;"
+	if _, err := sp.Write([]byte(in)); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stmts := sp.Statements()
+	if len(stmts) != 1 || stmts[0].SQL != in {
+		t.Fatalf("%q: expected a single statement %q, got %#v", in, in, stmts)
+	}
+}
+
+// TestStatementSplitterTrailingEmpty verifies that TrailingEmpty
+// distinguishes a statement immediately followed by more statement
+// text from one followed only by whitespace/comments.
+func TestStatementSplitterTrailingEmpty(t *testing.T) {
+	defer leaktest.AfterTest(t)()
+
+	tests := []struct {
+		in            string
+		trailingEmpty bool
+	}{
+		{in: "SELECT 1;", trailingEmpty: true},
+		{in: "SELECT 1; -- trailing comment", trailingEmpty: true},
+		{in: "SELECT 1; SELECT", trailingEmpty: false},
+	}
+
+	for _, test := range tests {
+		sp := NewStatementSplitter()
+		if _, err := sp.Write([]byte(test.in)); err != nil {
+			t.Fatalf("%q: unexpected error: %s", test.in, err)
+		}
+		stmts := sp.Statements()
+		if len(stmts) == 0 {
+			t.Fatalf("%q: expected at least one statement", test.in)
+		}
+		if got := stmts[len(stmts)-1].TrailingEmpty; got != test.trailingEmpty {
+			t.Errorf("%q: expected TrailingEmpty %v, got %v", test.in, test.trailingEmpty, got)
+		}
+	}
+}
@@ -0,0 +1,32 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package proto
+
+// AdminMergeRequest is the argument to the AdminMerge() method. It
+// requests that the range containing RequestHeader.Key be merged
+// with the range immediately to its right: the two ranges' keyspaces
+// and MVCC stats are combined and reassigned to the left-hand range,
+// and the right-hand range ceases to exist. AdminMerge is the inverse
+// of AdminSplit.
+type AdminMergeRequest struct {
+	RequestHeader
+}
+
+// AdminMergeResponse is the return value from the AdminMerge()
+// method.
+type AdminMergeResponse struct {
+	ResponseHeader
+}
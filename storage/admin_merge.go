@@ -0,0 +1,133 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package storage
+
+import (
+	"github.com/cockroachdb/cockroach/proto"
+	"github.com/cockroachdb/cockroach/storage/engine"
+	"github.com/cockroachdb/cockroach/util"
+)
+
+// raftCmd is the payload proposed via RangeManager.ProposeRaftCommand
+// for range-level administrative commands that must apply atomically
+// and identically on every replica. merge is set for commands raised
+// by AdminMerge; other admin commands proposed this way would add
+// their own field alongside it.
+type raftCmd struct {
+	merge *mergeDetails
+}
+
+// mergeDetails carries the state applyMerge needs to fold the
+// right-hand range into the left-hand one. It deliberately omits the
+// two ranges' MVCC stats -- see applyMerge for why -- and carries
+// RemovedRangeID so applyMerge can confirm it is tearing down the
+// range AdminMerge actually looked up, not just whatever currently
+// occupies that keyspace.
+type mergeDetails struct {
+	UpdatedEndKey  proto.Key
+	RemovedRangeID int64
+}
+
+// AdminMerge merges the range identified by args.Key with the range
+// immediately to its right. It is the inverse of AdminSplit: the
+// right-hand range's keyspace is reassigned to this range under Raft.
+// The command carries only the two ranges' identities, not their
+// stats -- see applyMerge for why.
+//
+// The merge is rejected if either range has a split in flight -- the
+// keyspace on either side of the boundary being merged isn't stable
+// yet -- or if the two ranges' zone configs don't match, since a
+// merge has no way to reconcile differing replication or placement
+// requirements on either side.
+func (r *Range) AdminMerge(args proto.AdminMergeRequest) (proto.AdminMergeResponse, error) {
+	var reply proto.AdminMergeResponse
+
+	rightRng := r.rm.LookupRange(r.Desc().EndKey, nil)
+	if rightRng == nil {
+		return reply, util.Errorf("no range immediately to the right of %s to merge with", r)
+	}
+
+	if r.hasInFlightSplit() || rightRng.hasInFlightSplit() {
+		return reply, util.Errorf("cannot merge %s and %s: a split is in flight", r, rightRng)
+	}
+	if !r.zoneConfigMatches(rightRng) {
+		return reply, util.Errorf("cannot merge %s and %s: zone configs do not match", r, rightRng)
+	}
+
+	if err := r.rm.ProposeRaftCommand(raftCmd{
+		merge: &mergeDetails{
+			UpdatedEndKey:  rightRng.Desc().EndKey,
+			RemovedRangeID: rightRng.Desc().RangeID,
+		},
+	}); err != nil {
+		return reply, err
+	}
+
+	return reply, nil
+}
+
+// applyRaftCommand is invoked on every replica of r once a raftCmd
+// proposed via ProposeRaftCommand commits under Raft. It dispatches
+// to the command-specific apply logic; AdminMerge is the only command
+// that needs one today, but any future admin command carried the same
+// way would get a case here too.
+func (r *Range) applyRaftCommand(cmd raftCmd) error {
+	if cmd.merge != nil {
+		return r.applyMerge(cmd.merge)
+	}
+	return nil
+}
+
+// applyMerge is invoked when a merge command commits under Raft, on
+// every replica of r. It widens r's descriptor to absorb the removed
+// range's keyspace and recomputes r's MVCC stats from a fresh
+// snapshot of the merged keyspace, mirroring the recomputation
+// AdminSplit relies on for its post-split ranges, rather than
+// combining the two ranges' previously recorded stats. Those stats
+// were current when AdminMerge was called, but concurrent writers can
+// land puts on either side of the boundary before the command
+// actually applies, so summing them would bake in a stale answer;
+// computing from a snapshot at apply time does not.
+//
+// Having absorbed the keyspace, it tears down this replica's copy of
+// the right-hand range: AdminMerge's doc comment promises the
+// right-hand range ceases to exist, not just that its keyspace gets a
+// new owner.
+func (r *Range) applyMerge(details *mergeDetails) error {
+	rightRng := r.rm.LookupRange(r.Desc().EndKey, nil)
+	if rightRng == nil {
+		return util.Errorf("no range immediately to the right of %s to absorb", r)
+	}
+	if rightRng.Desc().RangeID != details.RemovedRangeID {
+		return util.Errorf("range immediately to the right of %s is %s, not the range %d the merge removed",
+			r, rightRng, details.RemovedRangeID)
+	}
+
+	mergedDesc := *r.Desc()
+	mergedDesc.EndKey = details.UpdatedEndKey
+
+	mergedStats, err := engine.MVCCComputeStats(r.rm.Engine(), mergedDesc.StartKey, mergedDesc.EndKey)
+	if err != nil {
+		return util.Errorf("recomputing merged range stats: %s", err)
+	}
+
+	r.setDescAndStats(&mergedDesc, mergedStats)
+
+	if err := r.rm.RemoveRange(rightRng); err != nil {
+		return util.Errorf("removing merged-away range %s: %s", rightRng, err)
+	}
+	return nil
+}
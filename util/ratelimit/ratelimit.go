@@ -0,0 +1,158 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+// Package ratelimit provides a small token-bucket rate limiter for
+// throttling byte-oriented traffic, along with the throughput
+// statistics needed to reason about it.
+package ratelimit
+
+import (
+	"fmt"
+	"sync"
+	"time"
+)
+
+// pollInterval bounds how long Limit sleeps between checks of the
+// token bucket, the done channel and the deadline while it waits for
+// capacity to free up.
+const pollInterval = 5 * time.Millisecond
+
+// DefaultAlpha is the smoothing factor used for the exponential
+// moving average rate computed by a Monitor.
+const DefaultAlpha = 0.2
+
+// Monitor is a token-bucket rate limiter that doubles as a simple
+// throughput recorder. Every transfer accounted for via Update or
+// Limit refreshes a cumulative byte/sample count, the most recent
+// sample rate, and an exponential moving average rate:
+//
+//	rEMA = alpha*rSample + (1-alpha)*rEMA
+//
+// Sharing a single Monitor across goroutines caps their aggregate
+// byte rate; giving each goroutine its own Monitor caps them
+// individually.
+type Monitor struct {
+	mu sync.Mutex
+
+	ratePerSec float64 // bytes/sec ceiling; <= 0 disables throttling
+	alpha      float64
+
+	tokens     float64
+	lastRefill time.Time
+
+	bytes      int64
+	samples    int64
+	rSample    float64
+	rEMA       float64
+	lastSample time.Time
+}
+
+// NewMonitor creates a Monitor that throttles Limit callers to
+// ratePerSec bytes/sec. A ratePerSec <= 0 disables throttling, so
+// Limit never blocks, though it still records statistics.
+func NewMonitor(ratePerSec float64) *Monitor {
+	now := time.Now()
+	return &Monitor{
+		ratePerSec: ratePerSec,
+		alpha:      DefaultAlpha,
+		tokens:     ratePerSec,
+		lastRefill: now,
+		lastSample: now,
+	}
+}
+
+// Update records that n bytes were transferred, refreshing the
+// sample and EMA rates. Callers that go through Limit do not need to
+// call Update themselves; Limit does so on their behalf.
+func (m *Monitor) Update(n int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.update(n)
+}
+
+// update is Update's implementation; callers must hold m.mu.
+func (m *Monitor) update(n int64) {
+	now := time.Now()
+	elapsed := now.Sub(m.lastSample).Seconds()
+	m.lastSample = now
+	m.bytes += n
+	m.samples++
+	if elapsed > 0 {
+		m.rSample = float64(n) / elapsed
+		m.rEMA = m.alpha*m.rSample + (1-m.alpha)*m.rEMA
+	}
+}
+
+// Stats returns the cumulative bytes and sample count recorded so
+// far, along with the most recent sample rate and the EMA rate, both
+// in bytes/sec.
+func (m *Monitor) Stats() (bytes, samples int64, rSample, rEMA float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return m.bytes, m.samples, m.rSample, m.rEMA
+}
+
+// refill tops up the token bucket for elapsed time, capping it at
+// capacity; callers must hold m.mu.
+func (m *Monitor) refill(capacity float64) {
+	now := time.Now()
+	elapsed := now.Sub(m.lastRefill).Seconds()
+	m.lastRefill = now
+	m.tokens += elapsed * m.ratePerSec
+	if m.tokens > capacity {
+		m.tokens = capacity
+	}
+}
+
+// Limit blocks the caller until n bytes' worth of tokens are
+// available under the configured rate ceiling, then records the
+// transfer via Update. It returns early with an error if done is
+// closed, or once deadline passes; a zero deadline means no
+// deadline. A Monitor configured with ratePerSec <= 0 never blocks.
+//
+// The bucket's capacity is normally one second's worth of tokens,
+// but a single call for more than that temporarily raises the
+// capacity to n so it can still accumulate enough tokens to proceed,
+// rather than capping below n and waiting forever.
+func (m *Monitor) Limit(n int64, deadline time.Time, done <-chan struct{}) error {
+	if m.ratePerSec <= 0 {
+		m.Update(n)
+		return nil
+	}
+	capacity := m.ratePerSec
+	if float64(n) > capacity {
+		capacity = float64(n)
+	}
+	for {
+		m.mu.Lock()
+		m.refill(capacity)
+		if m.tokens >= float64(n) {
+			m.tokens -= float64(n)
+			m.update(n)
+			m.mu.Unlock()
+			return nil
+		}
+		m.mu.Unlock()
+
+		if !deadline.IsZero() && !time.Now().Before(deadline) {
+			return fmt.Errorf("ratelimit: deadline exceeded waiting for %d bytes", n)
+		}
+		select {
+		case <-done:
+			return fmt.Errorf("ratelimit: cancelled waiting for %d bytes", n)
+		case <-time.After(pollInterval):
+		}
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2014 The Cockroach Authors.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or
+// implied. See the License for the specific language governing
+// permissions and limitations under the License. See the AUTHORS file
+// for names of contributors.
+
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+// TestMonitorStats verifies that Update accumulates bytes and
+// samples and computes a sane EMA rate.
+func TestMonitorStats(t *testing.T) {
+	m := NewMonitor(0)
+	// Backdate the previous sample by a second before each update, so
+	// each sees about one second of elapsed time. update measures real
+	// time, so each rate comes out a hair below n bytes/sec.
+	m.lastSample = m.lastSample.Add(-1 * time.Second)
+	m.update(100)
+	m.lastSample = m.lastSample.Add(-1 * time.Second)
+	m.update(200)
+
+	bytes, samples, rSample, rEMA := m.Stats()
+	if bytes != 300 {
+		t.Errorf("expected 300 cumulative bytes, got %d", bytes)
+	}
+	if samples != 2 {
+		t.Errorf("expected 2 samples, got %d", samples)
+	}
+	if rSample > 200 || rSample < 199 {
+		t.Errorf("expected rSample of about 200 bytes/sec, got %f", rSample)
+	}
+	if rEMA <= 0 || rEMA > rSample {
+		t.Errorf("expected 0 < rEMA <= rSample, got rEMA=%f rSample=%f", rEMA, rSample)
+	}
+}
+
+// TestMonitorLimitUnbounded verifies that a Monitor with no
+// configured rate never blocks.
+func TestMonitorLimitUnbounded(t *testing.T) {
+	m := NewMonitor(0)
+	done := make(chan struct{})
+	if err := m.Limit(1<<20, time.Time{}, done); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if bytes, _, _, _ := m.Stats(); bytes != 1<<20 {
+		t.Errorf("expected Limit to record bytes even when unbounded, got %d", bytes)
+	}
+}
+
+// TestMonitorLimitThrottles verifies that Limit blocks a caller
+// requesting more bytes than the configured rate allows in one shot,
+// and that it eventually succeeds once tokens accrue.
+func TestMonitorLimitThrottles(t *testing.T) {
+	m := NewMonitor(1000) // 1000 bytes/sec, starts with a full bucket
+	done := make(chan struct{})
+
+	// Drain the initial bucket.
+	if err := m.Limit(1000, time.Time{}, done); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	start := time.Now()
+	if err := m.Limit(100, time.Time{}, done); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("expected Limit to block for tokens to refill, only waited %s", elapsed)
+	}
+}
+
+// TestMonitorLimitOverCapacity verifies that a single Limit call for
+// more bytes than one second's worth of tokens still completes,
+// rather than hanging forever because the bucket's capacity caps
+// below the requested amount. It passes a zero deadline and a nil
+// done so there is nothing to rescue it if the wait is unbounded.
+func TestMonitorLimitOverCapacity(t *testing.T) {
+	m := NewMonitor(10000) // 10000 bytes/sec, starts with a full bucket
+	if err := m.Limit(15000, time.Time{}, nil); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if bytes, _, _, _ := m.Stats(); bytes != 15000 {
+		t.Errorf("expected Limit to record bytes once it completes, got %d", bytes)
+	}
+}
+
+// TestMonitorLimitDeadlineExceeded verifies that Limit gives up once
+// the deadline passes rather than blocking forever.
+func TestMonitorLimitDeadlineExceeded(t *testing.T) {
+	m := NewMonitor(1) // 1 byte/sec, effectively never catches up
+	done := make(chan struct{})
+
+	if err := m.Limit(1, time.Time{}, done); err != nil {
+		t.Fatalf("unexpected error draining initial bucket: %s", err)
+	}
+	if err := m.Limit(1000, time.Now().Add(20*time.Millisecond), done); err == nil {
+		t.Error("expected Limit to report a deadline error, got nil")
+	}
+}
+
+// TestMonitorLimitCancel verifies that Limit returns once done is
+// closed instead of blocking forever.
+func TestMonitorLimitCancel(t *testing.T) {
+	m := NewMonitor(1)
+	done := make(chan struct{})
+
+	if err := m.Limit(1, time.Time{}, done); err != nil {
+		t.Fatalf("unexpected error draining initial bucket: %s", err)
+	}
+	go func() {
+		time.Sleep(20 * time.Millisecond)
+		close(done)
+	}()
+	if err := m.Limit(1000, time.Time{}, done); err == nil {
+		t.Error("expected Limit to report a cancellation error, got nil")
+	}
+}